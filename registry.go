@@ -0,0 +1,116 @@
+package semaphore
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrUnknownSemaphore is the error returned by a Registry method when it's
+// called for a name that hasn't been Configure()d.
+var ErrUnknownSemaphore = errors.New("semaphore: no semaphore configured with that name")
+
+// Registry manages a set of named semaphores, keyed by string, so an
+// application can declare all of its per-resource concurrency limits in one
+// place instead of threading individual Semaphore values through its call
+// graph. This is useful when, for example, CPU-heavy and memory-heavy
+// operations need distinct concurrency caps.
+type Registry struct {
+	mu    sync.RWMutex
+	semas map[string]Semaphore
+}
+
+// NewRegistry returns a new, empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{semas: make(map[string]Semaphore)}
+}
+
+// Configure creates the named semaphore with the given size, replacing any
+// semaphore already configured under that name. It must be called before
+// Acquire() or AcquireContext() is used for that name.
+//
+// Permits already acquired against a semaphore this replaces remain valid;
+// their Releaser is bound to the old semaphore and is unaffected by the
+// replacement.
+func (r *Registry) Configure(name string, size int) error {
+	sema, err := New(size)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	r.semas[name] = sema
+	r.mu.Unlock()
+
+	return nil
+}
+
+// Acquire is a blocking function to take a permit from the named semaphore.
+// It returns ErrUnknownSemaphore if name hasn't been Configure()d, or
+// ErrUnusable if the named semaphore has been Close()d.
+//
+// The returned Releaser must be used exactly once to give the permit back;
+// calling its Release() more than once panics.
+func (r *Registry) Acquire(name string) (Releaser, error) {
+	sema, err := r.semaphore(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := sema.Acquire(); err != nil {
+		return nil, err
+	}
+
+	return &permit{releaser: sema}, nil
+}
+
+// AcquireContext behaves like Acquire, except it also returns early with
+// ctx.Err() if the provided context is cancelled or its deadline is
+// exceeded before a permit becomes available.
+func (r *Registry) AcquireContext(ctx context.Context, name string) (Releaser, error) {
+	sema, err := r.semaphore(name)
+	if err != nil {
+		return nil, err
+	}
+
+	ctxSema, ok := sema.(ContextAcquirer)
+	if !ok {
+		return nil, errors.New("semaphore: named semaphore does not support context-aware acquisition")
+	}
+
+	if err := ctxSema.AcquireContext(ctx); err != nil {
+		return nil, err
+	}
+
+	return &permit{releaser: sema}, nil
+}
+
+func (r *Registry) semaphore(name string) (Semaphore, error) {
+	r.mu.RLock()
+	sema, ok := r.semas[name]
+	r.mu.RUnlock()
+
+	if !ok {
+		return nil, ErrUnknownSemaphore
+	}
+
+	return sema, nil
+}
+
+// permit is the Releaser handed back by a Registry's Acquire() and
+// AcquireContext(). It decouples nothing: unlike a bare Semaphore, where
+// Release() can be called by any goroutine holding a reference to it, a
+// permit is a single-use token that panics if released more than once.
+type permit struct {
+	released int32
+	releaser Releaser
+}
+
+func (p *permit) Release() error {
+	if !atomic.CompareAndSwapInt32(&p.released, 0, 1) {
+		panic("semaphore: permit already released")
+	}
+
+	return p.releaser.Release()
+}
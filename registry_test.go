@@ -0,0 +1,114 @@
+package semaphore
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestNewRegistry(t *testing.T) {
+	reg := NewRegistry()
+
+	if reg == nil {
+		t.Fatal("NewRegistry() = <nil>, want != <nil>")
+	}
+
+	if reg.semas == nil {
+		t.Fatal("reg.semas = <nil>, want != <nil>")
+	}
+}
+
+func TestRegistry_Acquire_Unknown(t *testing.T) {
+	reg := NewRegistry()
+
+	if _, err := reg.Acquire("cpu"); err != ErrUnknownSemaphore {
+		t.Fatalf("reg.Acquire(\"cpu\") error = %v, want %s", err, ErrUnknownSemaphore)
+	}
+}
+
+func TestRegistry_Configure_InvalidSize(t *testing.T) {
+	reg := NewRegistry()
+
+	if err := reg.Configure("cpu", 0); err == nil {
+		t.Fatal("reg.Configure(\"cpu\", 0) error = <nil>, want non-nil")
+	}
+}
+
+func TestRegistry_AcquireRelease(t *testing.T) {
+	reg := NewRegistry()
+
+	if err := reg.Configure("cpu", 1); err != nil {
+		t.Fatalf("reg.Configure(\"cpu\", 1) = %s, want <nil>", err)
+	}
+
+	rel, err := reg.Acquire("cpu")
+
+	if err != nil {
+		t.Fatalf("reg.Acquire(\"cpu\") error = %s, want <nil>", err)
+	}
+
+	if rel == nil {
+		t.Fatal("reg.Acquire(\"cpu\") releaser = <nil>, want != <nil>")
+	}
+
+	if err := rel.Release(); err != nil {
+		t.Fatalf("rel.Release() = %s, want <nil>", err)
+	}
+}
+
+func TestRegistry_Acquire_DoubleReleasePanics(t *testing.T) {
+	reg := NewRegistry()
+
+	if err := reg.Configure("cpu", 1); err != nil {
+		t.Fatalf("reg.Configure(\"cpu\", 1) = %s, want <nil>", err)
+	}
+
+	rel, err := reg.Acquire("cpu")
+
+	if err != nil {
+		t.Fatalf("reg.Acquire(\"cpu\") error = %s, want <nil>", err)
+	}
+
+	if err := rel.Release(); err != nil {
+		t.Fatalf("rel.Release() = %s, want <nil>", err)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("rel.Release() did not panic on double-release")
+		}
+	}()
+
+	rel.Release()
+}
+
+func TestRegistry_AcquireContext(t *testing.T) {
+	reg := NewRegistry()
+
+	if err := reg.Configure("cpu", 1); err != nil {
+		t.Fatalf("reg.Configure(\"cpu\", 1) = %s, want <nil>", err)
+	}
+
+	rel, err := reg.AcquireContext(context.Background(), "cpu")
+
+	if err != nil {
+		t.Fatalf("reg.AcquireContext(ctx, \"cpu\") error = %s, want <nil>", err)
+	}
+
+	defer rel.Release()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*20)
+	defer cancel()
+
+	if _, err := reg.AcquireContext(ctx, "cpu"); err != context.DeadlineExceeded {
+		t.Fatalf("reg.AcquireContext(ctx, \"cpu\") error = %v, want %s", err, context.DeadlineExceeded)
+	}
+}
+
+func TestRegistry_AcquireContext_Unknown(t *testing.T) {
+	reg := NewRegistry()
+
+	if _, err := reg.AcquireContext(context.Background(), "cpu"); err != ErrUnknownSemaphore {
+		t.Fatalf("reg.AcquireContext(ctx, \"cpu\") error = %v, want %s", err, ErrUnknownSemaphore)
+	}
+}
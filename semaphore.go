@@ -1,8 +1,10 @@
 package semaphore
 
 import (
+	"context"
 	"errors"
 	"io"
+	"sync/atomic"
 )
 
 // ErrUnusable is the error returned if the semaphore isn't suitable for use,
@@ -28,16 +30,86 @@ type Acquirer interface {
 	Acquire() error
 }
 
+// ContextAcquirer is the interface for taking permits from a semaphore while
+// honoring a context.Context.
+type ContextAcquirer interface {
+	// AcquireContext behaves like Acquirer's Acquire, except it also returns
+	// early with ctx.Err() if the provided context is cancelled or its
+	// deadline is exceeded before a permit becomes available.
+	//
+	// As with Acquire, this returns ErrUnusable if Close() has been called on
+	// the semaphore. In this case you'd need to use New() to obtain a new
+	// usable semaphore.
+	AcquireContext(ctx context.Context) error
+}
+
+// TryAcquirer is the interface for taking a permit from a semaphore without
+// blocking.
+type TryAcquirer interface {
+	// TryAcquire is a non-blocking function to take a permit from the
+	// semaphore. It returns true if the permit was acquired and the work can
+	// start.
+	//
+	// It returns false if no permit was immediately available, including
+	// when Close() has been called on the semaphore. Unlike Acquire, this
+	// does not return an error since a false return doesn't distinguish
+	// between "would have blocked" and "unusable".
+	TryAcquire() bool
+}
+
+// BatchAcquirer is the interface for taking more than one permit from a
+// semaphore in a single call.
+type BatchAcquirer interface {
+	// AcquireN behaves like Acquirer's Acquire, except it blocks until n
+	// permits are available and hands them all back at once.
+	//
+	// This returns ErrUnusable if Close() has been called on the semaphore.
+	// If it returns any other error partway through acquiring the n
+	// permits, the permits it already took are returned before this
+	// function returns, so callers never need to reconcile a partial grant.
+	AcquireN(n int) error
+}
+
+// BatchReleaser is the interface for releasing more than one permit back to
+// a semaphore in a single call.
+type BatchReleaser interface {
+	// ReleaseN behaves like Releaser's Release, except it returns n permits
+	// at once. Like Release, permits that were granted before Close() was
+	// called may always be returned here without error.
+	//
+	// ReleaseN panics if n is greater than the number of permits currently
+	// held, the same as calling Release() that many times over would.
+	ReleaseN(n int) error
+}
+
+// Inspector is the interface for introspecting a semaphore's saturation,
+// useful for building metrics or health endpoints around a concurrency
+// limit.
+type Inspector interface {
+	// Cap returns the total number of permits the semaphore can issue.
+	Cap() int
+
+	// InUse returns the number of permits currently issued and not yet
+	// released.
+	InUse() int
+
+	// Waiting returns the number of goroutines currently blocked waiting
+	// for a permit to become available, via Acquire(), AcquireContext(), or
+	// AcquireN() (each permit an in-flight AcquireN() is still waiting on
+	// counts individually).
+	Waiting() int
+}
+
 // Releaser is the interface for releasing permits back to a semaphore.
 type Releaser interface {
-	// Release is a non-blocking function to release the semaphore. If an error is returned
-	// from this function, the release was successful but the semaphore cannot be
-	// used to acquire another permit.
+	// Release is a non-blocking function to release a permit back to the
+	// semaphore. A permit that was granted by Acquire, AcquireContext, or
+	// TryAcquire before Close() was called may always be returned here
+	// without error, even after the semaphore has been closed.
 	//
-	// In other words, if this returns an error it should not be treated as a
-	// failure. For example, if Close() is called, followed by Release(), this
-	// function would return an ErrUnusable error. In this case you'd need to
-	// use New() to obtain a new usable semaphore.
+	// Release panics if it's called more times than permits were acquired,
+	// since that indicates a bug in the caller rather than a condition the
+	// caller can recover from.
 	Release() error
 }
 
@@ -75,44 +147,148 @@ func New(size int) (Semaphore, error) {
 		return nil, errors.New("size argument must be greater than 0")
 	}
 
-	return &semaphore{c: make(chan struct{}, size)}, nil
+	tokens := make(chan struct{}, size)
+
+	for i := 0; i < size; i++ {
+		tokens <- struct{}{}
+	}
+
+	return &semaphore{tokens: tokens, done: make(chan struct{})}, nil
 }
 
+// semaphore holds its available permits as tokens buffered in a channel:
+// Acquire takes a token out, Release puts one back. This is the inverse of
+// an earlier implementation that sent on acquire and received on release,
+// which made Close() (which can only safely close a channel once) awkward
+// to reconcile with "a permit held at Close time can still be released".
+// Here Close() never touches the tokens channel, it only closes done, so
+// Release always has a real, empty slot to send back into.
 type semaphore struct {
-	c chan struct{}
+	tokens  chan struct{}
+	done    chan struct{}
+	closed  int32
+	waiting int64
+}
+
+func (s *semaphore) Acquire() error {
+	return s.AcquireN(1)
+}
+
+func (s *semaphore) AcquireContext(ctx context.Context) error {
+	return s.acquireOne(ctx)
 }
 
-func (s *semaphore) Acquire() (err error) {
-	defer func() {
-		// this should catch panics for writing to a closed channel
-		if r := recover(); r != nil {
-			err = ErrUnusable
+func (s *semaphore) TryAcquire() bool {
+	if atomic.LoadInt32(&s.closed) == 1 {
+		return false
+	}
+
+	select {
+	case <-s.tokens:
+		if atomic.LoadInt32(&s.closed) == 1 {
+			// same race as acquireOne: give the token back rather than
+			// letting a concurrent Close() lose to it
+			s.tokens <- struct{}{}
+			return false
 		}
-	}()
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *semaphore) AcquireN(n int) error {
+	if n < 1 {
+		return errors.New("n argument must be greater than 0")
+	}
 
-	s.c <- struct{}{}
+	for i := 0; i < n; i++ {
+		if err := s.acquireOne(context.Background()); err != nil {
+			// give back the i permits we already took, so a failed
+			// AcquireN never leaves the caller holding a partial grant
+			for ; i > 0; i-- {
+				s.tokens <- struct{}{}
+			}
 
-	return
+			return err
+		}
+	}
+
+	return nil
 }
 
-func (s *semaphore) Release() error {
-	if _, ok := <-s.c; !ok {
+func (s *semaphore) acquireOne(ctx context.Context) error {
+	if atomic.LoadInt32(&s.closed) == 1 {
 		return ErrUnusable
 	}
 
-	return nil
+	atomic.AddInt64(&s.waiting, 1)
+	defer atomic.AddInt64(&s.waiting, -1)
+
+	select {
+	case <-s.tokens:
+		if atomic.LoadInt32(&s.closed) == 1 {
+			// Close() raced with this grant: s.done and s.tokens were both
+			// ready, and select doesn't prefer one over the other. Give the
+			// token back so InUse() stays accurate, and report the
+			// semaphore as unusable like Close() promises, instead of
+			// letting the race decide whether this call sees a permit.
+			s.tokens <- struct{}{}
+			return ErrUnusable
+		}
+		return nil
+	case <-s.done:
+		return ErrUnusable
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Cap returns the total number of permits the semaphore can issue.
+func (s *semaphore) Cap() int {
+	return cap(s.tokens)
+}
+
+// InUse returns the number of permits currently issued and not yet
+// released.
+func (s *semaphore) InUse() int {
+	return cap(s.tokens) - len(s.tokens)
 }
 
-func (s *semaphore) Close() (err error) {
-	// XXX(theckman): catch panic if semaphore channel already closed,
-	// return ErrAlreadyClosed if so
-	defer func() {
-		if r := recover(); r != nil {
-			err = ErrAlreadyClosed
+// Waiting returns the number of goroutines currently blocked waiting for a
+// permit to become available, via Acquire(), AcquireContext(), or
+// AcquireN() (each permit an in-flight AcquireN() is still waiting on
+// counts individually).
+func (s *semaphore) Waiting() int {
+	return int(atomic.LoadInt64(&s.waiting))
+}
+
+func (s *semaphore) Release() error {
+	return s.ReleaseN(1)
+}
+
+func (s *semaphore) ReleaseN(n int) error {
+	if n < 1 {
+		return errors.New("n argument must be greater than 0")
+	}
+
+	for i := 0; i < n; i++ {
+		select {
+		case s.tokens <- struct{}{}:
+		default:
+			panic("semaphore: released more permits than were acquired")
 		}
-	}()
+	}
 
-	close(s.c)
+	return nil
+}
 
-	return
+func (s *semaphore) Close() error {
+	if !atomic.CompareAndSwapInt32(&s.closed, 0, 1) {
+		return ErrAlreadyClosed
+	}
+
+	close(s.done)
+
+	return nil
 }
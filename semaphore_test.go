@@ -5,12 +5,22 @@
 package semaphore
 
 import (
+	"context"
 	"reflect"
 	"runtime"
 	"testing"
 	"time"
 )
 
+func newTestSemaphore(size int) *semaphore {
+	sema, err := New(size)
+	if err != nil {
+		panic(err)
+	}
+
+	return sema.(*semaphore)
+}
+
 func TestNew_InvalidSizeArgument(t *testing.T) {
 	var sema Semaphore
 	var err error
@@ -48,13 +58,17 @@ func TestNew(t *testing.T) {
 		t.Fatalf("type assertion failed: sem = %s, want *semaphore", reflect.ValueOf(sema).String())
 	}
 
-	if semCap := cap(castSem.c); semCap != 42 {
-		t.Fatalf("cap(castSem.c) = %d, want 42", semCap)
+	if semCap := cap(castSem.tokens); semCap != 42 {
+		t.Fatalf("cap(castSem.tokens) = %d, want 42", semCap)
+	}
+
+	if len(castSem.tokens) != 42 {
+		t.Fatalf("len(castSem.tokens) = %d, want 42 (semaphore should start with all permits available)", len(castSem.tokens))
 	}
 }
 
 func Test_semaphoreAcquire(t *testing.T) {
-	sema := &semaphore{c: make(chan struct{}, 1)}
+	sema := newTestSemaphore(1)
 
 	defer sema.Close()
 
@@ -62,18 +76,13 @@ func Test_semaphoreAcquire(t *testing.T) {
 		t.Fatalf("sema.Acquire() = %s, want <nil>", err)
 	}
 
-	select {
-	case _, ok := <-sema.c:
-		if !ok {
-			t.Fatal("sema.c should not be closed")
-		}
-	default:
-		t.Fatal("channel is expected to have at least one message")
+	if inUse := sema.InUse(); inUse != 1 {
+		t.Fatalf("sema.InUse() = %d, want 1", inUse)
 	}
 }
 
 func Test_semaphoreAcquire_Closed(t *testing.T) {
-	sema := &semaphore{c: make(chan struct{}, 1)}
+	sema := newTestSemaphore(1)
 
 	sema.Close()
 
@@ -82,16 +91,288 @@ func Test_semaphoreAcquire_Closed(t *testing.T) {
 	}
 }
 
+func Test_semaphoreAcquireContext(t *testing.T) {
+	sema := newTestSemaphore(1)
+
+	defer sema.Close()
+
+	if err := sema.AcquireContext(context.Background()); err != nil {
+		t.Fatalf("sema.AcquireContext(context.Background()) = %s, want <nil>", err)
+	}
+
+	if inUse := sema.InUse(); inUse != 1 {
+		t.Fatalf("sema.InUse() = %d, want 1", inUse)
+	}
+}
+
+func Test_semaphoreAcquireContext_Closed(t *testing.T) {
+	sema := newTestSemaphore(1)
+
+	sema.Close()
+
+	if err := sema.AcquireContext(context.Background()); err != ErrUnusable {
+		t.Fatalf("sema.AcquireContext(context.Background()) = %s, want %s", err, ErrUnusable)
+	}
+}
+
+// Test_semaphoreAcquire_NoGrantAfterClose calls Close() to completion
+// before the subsequent Release() runs, then checks a still-blocked
+// Acquire() never wins the freed-up token. This is the guarantee that
+// matters: once Close() has returned, no later Release() may hand a real
+// permit to a pending waiter, no matter how many tokens it frees up.
+//
+// It repeats many times, since without the closed re-check in acquireOne
+// (and TryAcquire), whether the blocked select happens to pick s.tokens
+// over the already-closed s.done is a coin flip, not a guaranteed failure
+// every run.
+func Test_semaphoreAcquire_NoGrantAfterClose(t *testing.T) {
+	for i := 0; i < 2000; i++ {
+		sema := newTestSemaphore(1)
+
+		if err := sema.Acquire(); err != nil {
+			t.Fatalf("iteration %d: sema.Acquire() = %s, want <nil>", i, err)
+		}
+
+		errCh := make(chan error, 1)
+
+		go func() {
+			errCh <- sema.Acquire()
+		}()
+
+		runtime.Gosched()
+		time.Sleep(time.Microsecond * 50)
+
+		if err := sema.Close(); err != nil {
+			t.Fatalf("iteration %d: sema.Close() = %s, want <nil>", i, err)
+		}
+
+		if err := sema.Release(); err != nil {
+			t.Fatalf("iteration %d: sema.Release() = %s, want <nil>", i, err)
+		}
+
+		if err := <-errCh; err != ErrUnusable {
+			t.Fatalf("iteration %d: sema.Acquire() = %v, want %s (a blocked Acquire must never be granted a permit once Close() has run)", i, err, ErrUnusable)
+		}
+	}
+}
+
+func Test_semaphoreAcquireContext_CancelledContext(t *testing.T) {
+	sema := newTestSemaphore(1)
+
+	defer sema.Close()
+
+	sema.tokens = make(chan struct{}) // drain the lone permit so Acquire blocks
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := sema.AcquireContext(ctx); err != ctx.Err() {
+		t.Fatalf("sema.AcquireContext(ctx) = %s, want %s", err, ctx.Err())
+	}
+}
+
+func Test_semaphoreAcquireContext_DeadlineExceeded(t *testing.T) {
+	sema := newTestSemaphore(1)
+
+	defer sema.Close()
+
+	sema.tokens = make(chan struct{}) // drain the lone permit so Acquire blocks
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+
+	err := sema.AcquireContext(ctx)
+
+	if err != context.DeadlineExceeded {
+		t.Fatalf("sema.AcquireContext(ctx) = %v, want %s", err, context.DeadlineExceeded)
+	}
+}
+
+func Test_semaphoreTryAcquire(t *testing.T) {
+	sema := newTestSemaphore(1)
+
+	defer sema.Close()
+
+	if ok := sema.TryAcquire(); !ok {
+		t.Fatal("sema.TryAcquire() = false, want true")
+	}
+
+	if ok := sema.TryAcquire(); ok {
+		t.Fatal("sema.TryAcquire() = true, want false (semaphore should be full)")
+	}
+}
+
+func Test_semaphoreTryAcquire_Closed(t *testing.T) {
+	sema := newTestSemaphore(1)
+
+	sema.Close()
+
+	if ok := sema.TryAcquire(); ok {
+		t.Fatal("sema.TryAcquire() = true, want false")
+	}
+}
+
+func Test_semaphoreAcquireN(t *testing.T) {
+	sema := newTestSemaphore(3)
+
+	defer sema.Close()
+
+	if err := sema.AcquireN(2); err != nil {
+		t.Fatalf("sema.AcquireN(2) = %s, want <nil>", err)
+	}
+
+	if inUse := sema.InUse(); inUse != 2 {
+		t.Fatalf("sema.InUse() = %d, want 2", inUse)
+	}
+}
+
+func Test_semaphoreAcquireN_Closed(t *testing.T) {
+	sema := newTestSemaphore(3)
+
+	sema.Close()
+
+	if err := sema.AcquireN(2); err != ErrUnusable {
+		t.Fatalf("sema.AcquireN(2) = %s, want %s", err, ErrUnusable)
+	}
+
+	if inUse := sema.InUse(); inUse != 0 {
+		t.Fatalf("sema.InUse() = %d, want 0", inUse)
+	}
+}
+
+// Test_semaphoreAcquireN_PartialGrantReturned holds one of a two-permit
+// semaphore's permits before calling AcquireN(2), so the call can only take
+// the one free permit before it blocks waiting on the second. Closing the
+// semaphore while it's blocked there forces AcquireN to fail partway
+// through, which is what actually exercises the give-back loop -- closing
+// the semaphore up front, before any permit is taken, would short-circuit
+// on the very first iteration and never touch it.
+func Test_semaphoreAcquireN_PartialGrantReturned(t *testing.T) {
+	sema := newTestSemaphore(2)
+
+	defer sema.Close()
+
+	if err := sema.Acquire(); err != nil {
+		t.Fatalf("sema.Acquire() = %s, want <nil>", err)
+	}
+
+	errCh := make(chan error, 1)
+
+	go func() {
+		errCh <- sema.AcquireN(2)
+	}()
+
+	runtime.Gosched()
+	time.Sleep(time.Millisecond * 20)
+
+	if inUse := sema.InUse(); inUse != 2 {
+		t.Fatalf("sema.InUse() = %d, want 2 (AcquireN should hold the one free permit while it blocks waiting for the second)", inUse)
+	}
+
+	sema.Close()
+
+	if err := <-errCh; err != ErrUnusable {
+		t.Fatalf("sema.AcquireN(2) = %s, want %s", err, ErrUnusable)
+	}
+
+	if inUse := sema.InUse(); inUse != 1 {
+		t.Fatalf("sema.InUse() = %d, want 1 (the permit AcquireN partially took should have been given back, leaving only the one held before it was called)", inUse)
+	}
+}
+
+func Test_semaphoreAcquireN_InvalidArgument(t *testing.T) {
+	sema := newTestSemaphore(1)
+
+	defer sema.Close()
+
+	if err := sema.AcquireN(0); err == nil {
+		t.Fatal("sema.AcquireN(0) error = <nil>, want non-nil")
+	}
+}
+
+func Test_semaphoreCap(t *testing.T) {
+	sema := newTestSemaphore(5)
+
+	defer sema.Close()
+
+	if capacity := sema.Cap(); capacity != 5 {
+		t.Fatalf("sema.Cap() = %d, want 5", capacity)
+	}
+}
+
+func Test_semaphoreInUse(t *testing.T) {
+	sema := newTestSemaphore(2)
+
+	defer sema.Close()
+
+	if inUse := sema.InUse(); inUse != 0 {
+		t.Fatalf("sema.InUse() = %d, want 0", inUse)
+	}
+
+	if err := sema.Acquire(); err != nil {
+		t.Fatalf("sema.Acquire() = %s, want <nil>", err)
+	}
+
+	if inUse := sema.InUse(); inUse != 1 {
+		t.Fatalf("sema.InUse() = %d, want 1", inUse)
+	}
+
+	if err := sema.Release(); err != nil {
+		t.Fatalf("sema.Release() = %s, want <nil>", err)
+	}
+
+	if inUse := sema.InUse(); inUse != 0 {
+		t.Fatalf("sema.InUse() = %d, want 0", inUse)
+	}
+}
+
+func Test_semaphoreWaiting(t *testing.T) {
+	sema := newTestSemaphore(1)
+
+	defer sema.Close()
+
+	if err := sema.Acquire(); err != nil {
+		t.Fatalf("sema.Acquire() = %s, want <nil>", err)
+	}
+
+	errCh := make(chan error, 1)
+
+	go func() {
+		errCh <- sema.Acquire()
+	}()
+
+	runtime.Gosched()
+	time.Sleep(time.Millisecond * 20)
+
+	if waiting := sema.Waiting(); waiting != 1 {
+		t.Fatalf("sema.Waiting() = %d, want 1", waiting)
+	}
+
+	if err := sema.Release(); err != nil {
+		t.Fatalf("sema.Release() = %s, want <nil>", err)
+	}
+
+	if err := <-errCh; err != nil {
+		t.Fatalf("sema.Acquire() = %s, want <nil>", err)
+	}
+
+	if waiting := sema.Waiting(); waiting != 0 {
+		t.Fatalf("sema.Waiting() = %d, want 0", waiting)
+	}
+}
+
 func releaseIt(sema Semaphore, err chan<- error) {
 	err <- sema.Release()
 }
 
 func Test_semaphoreRelease(t *testing.T) {
-	sema := &semaphore{c: make(chan struct{}, 1)}
+	sema := newTestSemaphore(1)
 
 	defer sema.Close()
 
-	sema.c <- struct{}{}
+	if err := sema.Acquire(); err != nil {
+		t.Fatalf("sema.Acquire() = %s, want <nil>", err)
+	}
 
 	errCh := make(chan error)
 	timer := time.NewTimer(time.Second * 2)
@@ -111,10 +392,17 @@ func Test_semaphoreRelease(t *testing.T) {
 	}
 }
 
+// Test_semaphoreRelease_Closed verifies the fix for the historical bug where
+// Release() read from the acquire channel: once Close() had been called, any
+// legitimately held permit could no longer be returned without an
+// ErrUnusable error. Now Release() always succeeds for a permit acquired
+// before Close(), even after the semaphore is closed.
 func Test_semaphoreRelease_Closed(t *testing.T) {
-	sema := &semaphore{c: make(chan struct{}, 1)}
+	sema := newTestSemaphore(1)
 
-	sema.c <- struct{}{}
+	if err := sema.Acquire(); err != nil {
+		t.Fatalf("sema.Acquire() = %s, want <nil>", err)
+	}
 
 	sema.Close()
 
@@ -134,37 +422,68 @@ func Test_semaphoreRelease_Closed(t *testing.T) {
 	case _ = <-timer.C:
 		t.Fatal("semaphore Release did not complete after 2 seconds")
 	}
-
 }
 
-func encapsuateClose(ch chan struct{}) (panicked bool) {
+func Test_semaphoreRelease_PanicsOnOverRelease(t *testing.T) {
+	sema := newTestSemaphore(1)
+
+	defer sema.Close()
+
 	defer func() {
-		if recover() != nil {
-			panicked = true
+		if recover() == nil {
+			t.Fatal("sema.Release() did not panic, want panic on over-release")
 		}
 	}()
 
-	close(ch)
+	sema.Release()
+}
+
+func Test_semaphoreReleaseN(t *testing.T) {
+	sema := newTestSemaphore(3)
+
+	defer sema.Close()
+
+	if err := sema.AcquireN(3); err != nil {
+		t.Fatalf("sema.AcquireN(3) = %s, want <nil>", err)
+	}
+
+	if err := sema.ReleaseN(3); err != nil {
+		t.Fatalf("sema.ReleaseN(3) = %s, want <nil>", err)
+	}
 
-	return
+	if inUse := sema.InUse(); inUse != 0 {
+		t.Fatalf("sema.InUse() = %d, want 0", inUse)
+	}
+}
+
+func Test_semaphoreReleaseN_InvalidArgument(t *testing.T) {
+	sema := newTestSemaphore(1)
+
+	defer sema.Close()
+
+	if err := sema.ReleaseN(0); err == nil {
+		t.Fatal("sema.ReleaseN(0) error = <nil>, want non-nil")
+	}
 }
 
 func Test_semaphoreClose(t *testing.T) {
 	var err error
-	sema := &semaphore{c: make(chan struct{})}
+	sema := newTestSemaphore(1)
 
 	if err = sema.Close(); err != nil {
 		t.Fatalf("sema.Close() = %#v, want <nil>", err)
 	}
 
-	if panicked := encapsuateClose(sema.c); panicked != true {
-		t.Fatal("sema.Close() does not appear to have closed the channel")
+	select {
+	case <-sema.done:
+	default:
+		t.Fatal("sema.Close() does not appear to have closed the done channel")
 	}
 }
 
 func Test_semaphoreClose_AlreadyClosed(t *testing.T) {
 	var err error
-	sema := &semaphore{c: make(chan struct{})}
+	sema := newTestSemaphore(1)
 
 	if err = sema.Close(); err != nil {
 		t.Fatalf("sema.Close() = %#v, want <nil>", err)
@@ -0,0 +1,240 @@
+package semaphore
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+)
+
+// WeightedSemaphore is the interface needed to implement a functioning
+// weighted semaphore. Unlike Semaphore, a single permit request can consume
+// more than one unit of the semaphore's capacity, which a buffered channel
+// cannot express.
+type WeightedSemaphore interface {
+	// Acquire is a blocking function to take n permits from the semaphore.
+	// It blocks until n permits are available, the provided context is
+	// cancelled or exceeds its deadline, or Close() is called.
+	//
+	// If the error returned is nil, the permits have been acquired and the
+	// work can start. Otherwise the permits were not given and the work MUST
+	// NOT start: this returns ctx.Err() if the context ended the wait,
+	// ErrUnusable if Close() has been called on the semaphore, or an error
+	// if n is less than 1.
+	Acquire(ctx context.Context, n int64) error
+
+	// TryAcquire is a non-blocking function to take n permits from the
+	// semaphore. It returns true if the permits were acquired and the work
+	// can start, and false if n permits weren't immediately available,
+	// including when Close() has been called on the semaphore or n is less
+	// than 1.
+	TryAcquire(n int64) bool
+
+	// Release returns n permits to the semaphore. It wakes any waiters
+	// whose request now fits within the available capacity, starting from
+	// the front of the FIFO queue.
+	//
+	// Release panics if n is less than 1, or if n is greater than the
+	// number of permits currently held.
+	Release(n int64)
+
+	// Close is a non-blocking function that shuts the semaphore down,
+	// prevents it from issuing further permits, and unblocks every pending
+	// Acquire() with an ErrUnusable error.
+	//
+	// This function should return an ErrAlreadyClosed error if the
+	// semaphore has already been closed, but consumers should treat that as
+	// an advisory and not a fatal error.
+	Close() error
+}
+
+// NewWeighted returns a new WeightedSemaphore and takes a size argument to
+// define how many units of concurrent capacity the semaphore will issue.
+// The size argument must be greater than 0 or an error will be returned.
+func NewWeighted(size int64) (WeightedSemaphore, error) {
+	if size < 1 {
+		return nil, errors.New("size argument must be greater than 0")
+	}
+
+	return &weighted{size: size, done: make(chan struct{})}, nil
+}
+
+// waiter is an entry in a weighted semaphore's FIFO queue. ready is closed
+// by the goroutine that grants the waiter its permits.
+type waiter struct {
+	n     int64
+	ready chan struct{}
+}
+
+type weighted struct {
+	mu      sync.Mutex
+	size    int64
+	cur     int64
+	waiters list.List
+	done    chan struct{}
+	closed  bool
+}
+
+func (w *weighted) Acquire(ctx context.Context, n int64) error {
+	if n < 1 {
+		return errors.New("n argument must be greater than 0")
+	}
+
+	w.mu.Lock()
+
+	if w.closed {
+		w.mu.Unlock()
+		return ErrUnusable
+	}
+
+	if w.size-w.cur >= n && w.waiters.Len() == 0 {
+		w.cur += n
+		w.mu.Unlock()
+		return nil
+	}
+
+	if n > w.size {
+		// this request can never be satisfied by this semaphore's total
+		// capacity. Enqueuing it anyway would sit it at the front of the
+		// FIFO queue forever, since notifyWaitersLocked never grants past a
+		// waiter that doesn't fit -- permanently starving every waiter
+		// behind it. Instead, just wait for the caller to give up or the
+		// semaphore to close, the same way golang.org/x/sync/semaphore does.
+		w.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-w.done:
+			return ErrUnusable
+		}
+	}
+
+	ready := make(chan struct{})
+	elem := w.waiters.PushBack(&waiter{n: n, ready: ready})
+	w.mu.Unlock()
+
+	select {
+	case <-ready:
+		return nil
+
+	case <-w.done:
+		w.mu.Lock()
+		select {
+		case <-ready:
+			// granted concurrently with Close(); honor the grant
+		default:
+			w.waiters.Remove(elem)
+		}
+		w.mu.Unlock()
+		return ErrUnusable
+
+	case <-ctx.Done():
+		err := ctx.Err()
+
+		w.mu.Lock()
+		select {
+		case <-ready:
+			// acquired concurrently with the context ending; the permits
+			// are already ours, so honor the grant instead of the error
+			err = nil
+		default:
+			isFront := w.waiters.Front() == elem
+			w.waiters.Remove(elem)
+			// if the abandoned waiter was blocking the head of the queue,
+			// re-run the wake-up logic in case a smaller request behind it
+			// now fits. Skip it once closed, for the same reason Release()
+			// does: a queued waiter must never be granted a real permit
+			// after Close() has run.
+			if isFront && !w.closed {
+				w.notifyWaitersLocked()
+			}
+		}
+		w.mu.Unlock()
+
+		return err
+	}
+}
+
+func (w *weighted) TryAcquire(n int64) bool {
+	if n < 1 {
+		return false
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return false
+	}
+
+	if w.size-w.cur >= n && w.waiters.Len() == 0 {
+		w.cur += n
+		return true
+	}
+
+	return false
+}
+
+func (w *weighted) Release(n int64) {
+	if n < 1 {
+		panic("semaphore: n argument must be greater than 0")
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.cur -= n
+
+	if w.cur < 0 {
+		panic("semaphore: released more permits than are held")
+	}
+
+	// once Close() has run, no queued Acquire() may be granted a real
+	// permit -- even one freed up by this Release() -- since Acquire()'s
+	// own select races w.done against a waiter's ready channel, and a
+	// waiter that's already blocked has no way to prefer the former. w.mu
+	// is shared with Close(), so this check can never race with it.
+	if w.closed {
+		return
+	}
+
+	w.notifyWaitersLocked()
+}
+
+// notifyWaitersLocked walks the waiter queue from the front, granting
+// permits to any waiter whose request now fits in the available capacity.
+// It stops at the first waiter that doesn't fit, which keeps the queue
+// strictly FIFO and prevents large requests from being starved by a stream
+// of smaller ones behind them. w.mu must be held by the caller.
+func (w *weighted) notifyWaitersLocked() {
+	for {
+		e := w.waiters.Front()
+		if e == nil {
+			break
+		}
+
+		wt := e.Value.(*waiter)
+
+		if w.size-w.cur < wt.n {
+			break
+		}
+
+		w.cur += wt.n
+		w.waiters.Remove(e)
+		close(wt.ready)
+	}
+}
+
+func (w *weighted) Close() (err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return ErrAlreadyClosed
+	}
+
+	w.closed = true
+	close(w.done)
+
+	return nil
+}
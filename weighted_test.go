@@ -0,0 +1,369 @@
+package semaphore
+
+import (
+	"context"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestNewWeighted_InvalidSizeArgument(t *testing.T) {
+	var sema WeightedSemaphore
+	var err error
+	sema, err = NewWeighted(0)
+
+	if sema != nil {
+		sema.Close()
+		t.Fatalf("NewWeighted(0) semaphore %#v, want <nil>", sema)
+	}
+
+	if err == nil {
+		t.Fatalf("NewWeighted(0) error = <nil>, want %q", "size argument must be greater than 0")
+	}
+}
+
+func TestNewWeighted(t *testing.T) {
+	var sema WeightedSemaphore
+	var err error
+
+	sema, err = NewWeighted(42)
+
+	if err != nil {
+		t.Fatalf("NewWeighted(42) error = %q, want <nil>", err)
+	}
+
+	if sema == nil {
+		t.Fatalf("NewWeighted(42) semaphore = <nil>, want != <nil>")
+	}
+
+	defer sema.Close()
+
+	castSem, ok := sema.(*weighted)
+
+	if !ok {
+		t.Fatalf("type assertion failed: sem = %s, want *weighted", reflect.ValueOf(sema).String())
+	}
+
+	if castSem.size != 42 {
+		t.Fatalf("castSem.size = %d, want 42", castSem.size)
+	}
+}
+
+func Test_weightedAcquire(t *testing.T) {
+	sema := &weighted{size: 4, done: make(chan struct{})}
+
+	defer sema.Close()
+
+	if err := sema.Acquire(context.Background(), 3); err != nil {
+		t.Fatalf("sema.Acquire(ctx, 3) = %s, want <nil>", err)
+	}
+
+	if sema.cur != 3 {
+		t.Fatalf("sema.cur = %d, want 3", sema.cur)
+	}
+}
+
+func Test_weightedAcquire_InvalidArgument(t *testing.T) {
+	sema := &weighted{size: 1, done: make(chan struct{})}
+
+	defer sema.Close()
+
+	if err := sema.Acquire(context.Background(), 0); err == nil {
+		t.Fatal("sema.Acquire(ctx, 0) error = <nil>, want non-nil")
+	}
+
+	if sema.cur != 0 {
+		t.Fatalf("sema.cur = %d, want 0 (n < 1 must not be granted)", sema.cur)
+	}
+}
+
+func Test_weightedAcquire_Blocks(t *testing.T) {
+	sema := &weighted{size: 2, done: make(chan struct{})}
+
+	defer sema.Close()
+
+	if err := sema.Acquire(context.Background(), 2); err != nil {
+		t.Fatalf("sema.Acquire(ctx, 2) = %s, want <nil>", err)
+	}
+
+	errCh := make(chan error, 1)
+
+	go func() {
+		errCh <- sema.Acquire(context.Background(), 1)
+	}()
+
+	runtime.Gosched()
+
+	select {
+	case err := <-errCh:
+		t.Fatalf("sema.Acquire(ctx, 1) = %v, want to block until a Release()", err)
+	case <-time.After(time.Millisecond * 50):
+	}
+
+	sema.Release(2)
+
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Fatalf("sema.Acquire(ctx, 1) = %s, want <nil>", err)
+		}
+	case <-time.After(time.Second * 2):
+		t.Fatal("sema.Acquire(ctx, 1) did not unblock after Release()")
+	}
+}
+
+func Test_weightedAcquire_FIFO(t *testing.T) {
+	sema := &weighted{size: 1, done: make(chan struct{})}
+
+	defer sema.Close()
+
+	if err := sema.Acquire(context.Background(), 1); err != nil {
+		t.Fatalf("sema.Acquire(ctx, 1) = %s, want <nil>", err)
+	}
+
+	order := make(chan int, 2)
+
+	go func() {
+		sema.Acquire(context.Background(), 1)
+		order <- 1
+	}()
+
+	runtime.Gosched()
+	time.Sleep(time.Millisecond * 20)
+
+	go func() {
+		sema.Acquire(context.Background(), 1)
+		order <- 2
+	}()
+
+	runtime.Gosched()
+	time.Sleep(time.Millisecond * 20)
+
+	sema.Release(1)
+	first := <-order
+
+	sema.Release(1)
+	second := <-order
+
+	if first != 1 || second != 2 {
+		t.Fatalf("acquire order = %d, %d, want 1, 2 (FIFO)", first, second)
+	}
+}
+
+func Test_weightedAcquire_RequestLargerThanSize(t *testing.T) {
+	sema := &weighted{size: 2, done: make(chan struct{})}
+
+	errCh := make(chan error, 1)
+
+	go func() {
+		errCh <- sema.Acquire(context.Background(), 5)
+	}()
+
+	runtime.Gosched()
+	time.Sleep(time.Millisecond * 20)
+
+	// a request bigger than the semaphore's total size must not be queued:
+	// it could never be satisfied, and sitting at the front of the FIFO
+	// queue would starve every smaller request behind it forever
+	if err := sema.Acquire(context.Background(), 1); err != nil {
+		t.Fatalf("sema.Acquire(ctx, 1) = %s, want <nil> (an oversized request must not block other waiters)", err)
+	}
+
+	if sema.waiters.Len() != 0 {
+		t.Fatalf("sema.waiters.Len() = %d, want 0 (oversized request should never be enqueued)", sema.waiters.Len())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*20)
+	defer cancel()
+
+	if err := sema.Acquire(ctx, 5); err != context.DeadlineExceeded {
+		t.Fatalf("sema.Acquire(ctx, 5) = %v, want %s", err, context.DeadlineExceeded)
+	}
+
+	sema.Close()
+
+	select {
+	case err := <-errCh:
+		if err != ErrUnusable {
+			t.Fatalf("sema.Acquire(ctx, 5) = %v, want %s", err, ErrUnusable)
+		}
+	case <-time.After(time.Second * 2):
+		t.Fatal("sema.Acquire(ctx, 5) did not unblock after Close()")
+	}
+}
+
+func Test_weightedAcquire_NoGrantAfterClose(t *testing.T) {
+	sema := &weighted{size: 1, done: make(chan struct{})}
+
+	if err := sema.Acquire(context.Background(), 1); err != nil {
+		t.Fatalf("sema.Acquire(ctx, 1) = %s, want <nil>", err)
+	}
+
+	errCh := make(chan error, 1)
+
+	go func() {
+		errCh <- sema.Acquire(context.Background(), 1)
+	}()
+
+	runtime.Gosched()
+	time.Sleep(time.Millisecond * 20)
+
+	sema.Close()
+	sema.Release(1)
+
+	select {
+	case err := <-errCh:
+		if err != ErrUnusable {
+			t.Fatalf("sema.Acquire(ctx, 1) = %v, want %s (a queued waiter must not be granted a permit once Close() has run)", err, ErrUnusable)
+		}
+	case <-time.After(time.Second * 2):
+		t.Fatal("sema.Acquire(ctx, 1) did not unblock after Close()+Release()")
+	}
+}
+
+func Test_weightedAcquire_ContextCancelled(t *testing.T) {
+	sema := &weighted{size: 1, done: make(chan struct{})}
+
+	defer sema.Close()
+
+	if err := sema.Acquire(context.Background(), 1); err != nil {
+		t.Fatalf("sema.Acquire(ctx, 1) = %s, want <nil>", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*20)
+	defer cancel()
+
+	err := sema.Acquire(ctx, 1)
+
+	if err != context.DeadlineExceeded {
+		t.Fatalf("sema.Acquire(ctx, 1) = %v, want %s", err, context.DeadlineExceeded)
+	}
+
+	if sema.waiters.Len() != 0 {
+		t.Fatalf("sema.waiters.Len() = %d, want 0 (abandoned waiter should be removed)", sema.waiters.Len())
+	}
+}
+
+func Test_weightedAcquire_Closed(t *testing.T) {
+	sema := &weighted{size: 1, done: make(chan struct{})}
+
+	sema.Close()
+
+	if err := sema.Acquire(context.Background(), 1); err != ErrUnusable {
+		t.Fatalf("sema.Acquire(ctx, 1) = %s, want %s", err, ErrUnusable)
+	}
+}
+
+func Test_weightedAcquire_UnblocksOnClose(t *testing.T) {
+	sema := &weighted{size: 1, done: make(chan struct{})}
+
+	if err := sema.Acquire(context.Background(), 1); err != nil {
+		t.Fatalf("sema.Acquire(ctx, 1) = %s, want <nil>", err)
+	}
+
+	errCh := make(chan error, 1)
+
+	go func() {
+		errCh <- sema.Acquire(context.Background(), 1)
+	}()
+
+	runtime.Gosched()
+
+	sema.Close()
+
+	select {
+	case err := <-errCh:
+		if err != ErrUnusable {
+			t.Fatalf("sema.Acquire(ctx, 1) = %v, want %s", err, ErrUnusable)
+		}
+	case <-time.After(time.Second * 2):
+		t.Fatal("sema.Acquire(ctx, 1) did not unblock after Close()")
+	}
+}
+
+func Test_weightedTryAcquire(t *testing.T) {
+	sema := &weighted{size: 2, done: make(chan struct{})}
+
+	defer sema.Close()
+
+	if ok := sema.TryAcquire(2); !ok {
+		t.Fatal("sema.TryAcquire(2) = false, want true")
+	}
+
+	if ok := sema.TryAcquire(1); ok {
+		t.Fatal("sema.TryAcquire(1) = true, want false (semaphore should be full)")
+	}
+}
+
+func Test_weightedTryAcquire_InvalidArgument(t *testing.T) {
+	sema := &weighted{size: 1, done: make(chan struct{})}
+
+	defer sema.Close()
+
+	if ok := sema.TryAcquire(0); ok {
+		t.Fatal("sema.TryAcquire(0) = true, want false")
+	}
+
+	if sema.cur != 0 {
+		t.Fatalf("sema.cur = %d, want 0 (n < 1 must not be granted)", sema.cur)
+	}
+}
+
+func Test_weightedTryAcquire_Closed(t *testing.T) {
+	sema := &weighted{size: 2, done: make(chan struct{})}
+
+	sema.Close()
+
+	if ok := sema.TryAcquire(1); ok {
+		t.Fatal("sema.TryAcquire(1) = true, want false")
+	}
+}
+
+func Test_weightedRelease_PanicsOnInvalidArgument(t *testing.T) {
+	sema := &weighted{size: 2, done: make(chan struct{})}
+
+	defer sema.Close()
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("sema.Release(0) did not panic, want panic on n < 1")
+		}
+	}()
+
+	sema.Release(0)
+}
+
+func Test_weightedRelease_PanicsOnOverRelease(t *testing.T) {
+	sema := &weighted{size: 2, done: make(chan struct{})}
+
+	defer sema.Close()
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("sema.Release(1) did not panic, want panic on over-release")
+		}
+	}()
+
+	sema.Release(1)
+}
+
+func Test_weightedClose(t *testing.T) {
+	sema := &weighted{size: 1, done: make(chan struct{})}
+
+	if err := sema.Close(); err != nil {
+		t.Fatalf("sema.Close() = %#v, want <nil>", err)
+	}
+}
+
+func Test_weightedClose_AlreadyClosed(t *testing.T) {
+	sema := &weighted{size: 1, done: make(chan struct{})}
+
+	if err := sema.Close(); err != nil {
+		t.Fatalf("sema.Close() = %#v, want <nil>", err)
+	}
+
+	if err := sema.Close(); err != ErrAlreadyClosed {
+		t.Fatalf("sema.Close() = %#v, want %#v", err, ErrAlreadyClosed)
+	}
+}